@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// logger emits structured JSON events (e.g. {"msg":"scrape_ok","cursor":...,
+// "proxy":...,"latency_ms":...}) so operators can tail stdout with jq or
+// ship it to Loki instead of parsing ad-hoc log.Printf lines.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// Metrics holds the Prometheus collectors published on --metrics-addr so a
+// long-running "infinite fetch" is observable from outside the process.
+type Metrics struct {
+	PostsScraped       prometheus.Counter
+	PostsSkipped       prometheus.Counter
+	RetriesTotal       prometheus.Counter
+	InFlightWorkers    prometheus.Gauge
+	ProxyRequestsTotal *prometheus.CounterVec
+	HTTPStatusTotal    *prometheus.CounterVec
+	RequestLatency     prometheus.Histogram
+	LastFlushTimestamp prometheus.Gauge
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		PostsScraped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "scraper_posts_scraped_total",
+			Help: "Number of items successfully scraped.",
+		}),
+		PostsSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "scraper_posts_skipped_total",
+			Help: "Number of failed batch fetches, whether queued for retry or given up as permanently failed.",
+		}),
+		RetriesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "scraper_retries_total",
+			Help: "Number of retry attempts across all cursors.",
+		}),
+		InFlightWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "scraper_inflight_workers",
+			Help: "Number of batches currently being fetched.",
+		}),
+		ProxyRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scraper_proxy_requests_total",
+			Help: "Requests made per proxy, labeled by outcome (ok|fail).",
+		}, []string{"proxy", "outcome"}),
+		HTTPStatusTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scraper_http_status_total",
+			Help: "HTTP responses received, labeled by status code.",
+		}, []string{"status"}),
+		RequestLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "scraper_request_latency_seconds",
+			Help:    "End-to-end request latency.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		LastFlushTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "scraper_last_flush_timestamp_seconds",
+			Help: "Unix timestamp of the last successful results flush.",
+		}),
+	}
+
+	prometheus.MustRegister(
+		m.PostsScraped,
+		m.PostsSkipped,
+		m.RetriesTotal,
+		m.InFlightWorkers,
+		m.ProxyRequestsTotal,
+		m.HTTPStatusTotal,
+		m.RequestLatency,
+		m.LastFlushTimestamp,
+	)
+
+	return m
+}
+
+// Serve starts the /metrics HTTP server. Intended to be run in its own
+// goroutine; it logs and returns if the server fails to start, since an
+// optional observability endpoint shouldn't take down the scrape itself.
+func (m *Metrics) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logger.Info("metrics_listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("metrics_server_failed", "error", err)
+	}
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,11 +14,14 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"golang.org/x/net/proxy"
 )
 
 // I want this scraper to be a bit more production ready.
@@ -25,11 +29,27 @@ import (
 // Retrying IDs that have failed
 
 var (
-	START_ID        = flag.String("start-id", "", "Starting post ID (base36)")
+	START_ID        = flag.String("start-id", "", "Starting post ID (base36, reddit-info source only)")
 	PROXIES_STRING  = flag.String("proxies", "", "Comma-separated list of proxy URLs (optional)")
-	OUTPUT_FILENAME = flag.String("output-file", "scraped_posts.json", "Output JSON file")
+	OUTPUT_FILENAME = flag.String("output-file", "scraped_posts.json", "Output NDJSON file (one JSON object per line)")
+	RESUME          = flag.Bool("resume", false, "Resume from the last checkpoint instead of --start-id")
+
+	SOURCE       = flag.String("source", "reddit-info", "Scraper backend: reddit-info|reddit-listing|booru")
+	SUBREDDIT    = flag.String("subreddit", "", "Subreddit to crawl (reddit-listing source)")
+	LISTING_SIZE = flag.Int("listing-limit", 100, "Posts per page (reddit-listing source)")
+	BOORU_URL    = flag.String("booru-url", "", "Booru API base URL, e.g. https://api.rule34.xxx/index.php?page=dapi&s=post&q=index&json=1 (booru source)")
+	BOORU_TAGS   = flag.String("booru-tags", "", "Tag query string (booru source)")
+	BOORU_LIMIT  = flag.Int("booru-limit", 100, "Posts per page (booru source)")
+
+	INSECURE_TLS            = flag.Bool("insecure-tls", false, "Skip TLS certificate verification (useful with MITM'ing proxies)")
+	PROXY_TIMEOUT           = flag.Duration("proxy-timeout", 30*time.Second, "Per-request timeout")
+	MAX_IDLE_CONNS_PER_HOST = flag.Int("max-idle-conns-per-host", 10, "Max idle connections kept per host in each identity's transport")
+
+	METRICS_ADDR = flag.String("metrics-addr", "", "If set, serve Prometheus metrics at http://<addr>/metrics")
 )
 
+const CHECKPOINT_INTERVAL = 10 * time.Second
+
 // We can fetch max 100 post per request max
 const STEP_SIZE = 100
 const WORKERS = 10
@@ -44,7 +64,7 @@ var USER_AGENT_FILE string
 func main() {
 	flag.Parse()
 
-	if *START_ID == "" {
+	if *SOURCE == "reddit-info" && *START_ID == "" && !*RESUME {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -59,122 +79,291 @@ func main() {
 		}
 	}
 
+	var metrics *Metrics
+	if *METRICS_ADDR != "" {
+		metrics = NewMetrics()
+		go metrics.Serve(*METRICS_ADDR)
+	}
+
 	var proxies []string
 	if *PROXIES_STRING != "" {
 		proxies = strings.Split(*PROXIES_STRING, ",")
 	}
+	proxyPool := NewProxyPool(proxies, metrics)
 
-	// Parse starting ID to integer
-	startNum, err := strconv.ParseInt(*START_ID, 36, 64)
-	if err != nil {
-		log.Fatalf("Invalid starting post ID: %v", err)
+	var startNumFromStartID int64
+	if *SOURCE == "reddit-info" && !*RESUME {
+		var err error
+		startNumFromStartID, err = strconv.ParseInt(*START_ID, 36, 64)
+		if err != nil {
+			log.Fatalf("Invalid starting post ID: %v", err)
+		}
+	}
+
+	source, step := buildSource(startNumFromStartID)
+
+	if *SOURCE != "reddit-info" && *RESUME {
+		log.Fatalf("--resume is only supported with --source=reddit-info for now")
+	}
+
+	outputBase := strings.TrimSuffix(*OUTPUT_FILENAME, filepath.Ext(*OUTPUT_FILENAME))
+	checkpointFile := outputBase + "_progress.json"
+
+	var startNum, i int64
+	var resumedSkipped []SkippedCursor
+	if *RESUME {
+		cp, err := LoadCheckpoint(checkpointFile)
+		if err != nil {
+			log.Fatalf("Failed to resume from %s: %v", checkpointFile, err)
+		}
+		startNum = cp.StartNum
+		i = cp.Offset + step
+		resumedSkipped = cp.Skipped
+		source.(*RedditInfoSource).StartNum = startNum
+		logger.Info("resume", "offset", i, "pending_skipped", len(resumedSkipped))
+	} else if *SOURCE == "reddit-info" {
+		startNum = startNumFromStartID
+	}
+
+	checkpoint := NewCheckpointStore(checkpointFile, startNum, i, step)
+
+	workers := WORKERS
+	if source.Sequential() {
+		workers = 1
+		logger.Info("single_worker_forced", "source", *SOURCE)
 	}
 
-	tasks := make(chan int64, WORKERS)
-	results := make(chan []byte)
-	skipped := make(chan int64, WORKERS*2)
-	inflight := make(chan struct{}, WORKERS)
-	i := int64(0)
+	tasks := make(chan int64, workers)
+	results := make(chan Item)
+	failed := make(chan int64, workers)
+	inflight := make(chan struct{}, workers)
 
-	shutdown := false
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	var seqResult chan bool
+	if source.Sequential() {
+		seqResult = make(chan bool, 1)
+	}
+
+	for _, sk := range resumedSkipped {
+		checkpoint.RestoreSkipped(sk.Cursor, sk.Attempts, sk.NextEligible)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 	go func() {
-		<-sigCh
-		log.Println("Received shutdown signal")
-		shutdown = true
+		<-ctx.Done()
+		logger.Info("shutdown_signal_received")
 	}()
 
 	go func() {
-		// Indefinitely feed new IDs
-		// Retrying IDs from the skipped channel first
+		// Indefinitely feed new IDs, retrying cursors whose backoff has
+		// elapsed first. Only closes tasks once ctx is cancelled, and only
+		// after giving back any inflight permit it grabbed but didn't use,
+		// so workers range out of tasks cleanly instead of racing a close
+		// against an in-progress send.
+		defer close(tasks)
 		for {
-			if shutdown {
-				close(tasks)
+			select {
+			case <-ctx.Done():
 				return
+			case inflight <- struct{}{}:
 			}
-			inflight <- struct{}{}
-			var nextId int64
-			if len(skipped) > 0 {
-				nextId = <-skipped
+
+			var cursor int64
+			frontier := false
+			if retry, ok := checkpoint.NextRetry(time.Now()); ok {
+				cursor = retry
 			} else {
-				i += STEP_SIZE
-				nextId = startNum + i
+				cursor = i
+				frontier = true
+			}
+
+			select {
+			case tasks <- cursor:
+			case <-ctx.Done():
+				<-inflight
+				return
+			}
+
+			if !frontier {
+				continue
+			}
+			if !source.Sequential() {
+				_, next := source.NextBatch(i)
+				i = next
+				continue
+			}
+
+			// A Sequential source only learns the next page's pagination
+			// token by parsing this page's response, so the frontier can't
+			// advance past a cursor until it's confirmed to have succeeded.
+			// A failed cursor falls back to the NextRetry backoff path
+			// above and keeps blocking the frontier until it does, instead
+			// of silently re-fetching (or skipping) a page whose token was
+			// never recorded.
+			select {
+			case succeeded := <-seqResult:
+				if succeeded {
+					_, next := source.NextBatch(i)
+					i = next
+				}
+			case <-ctx.Done():
+				return
 			}
-			tasks <- nextId
 		}
 	}()
 
 	var wg sync.WaitGroup
 
-	for i := 0; i < WORKERS; i++ {
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go scrapeWorker(&wg, tasks, skipped, results, inflight, proxies, userAgents)
+		go scrapeWorker(ctx, &wg, tasks, failed, results, inflight, proxyPool, userAgents, checkpoint, source, metrics, seqResult)
+	}
+
+	store, err := NewNDJSONStore(*OUTPUT_FILENAME)
+	if err != nil {
+		log.Fatalf("Failed to open output file: %v", err)
 	}
+	go writeResultsToFile(results, store, metrics)
+	go writeFailedToFile(failed, outputBase+"_failed.json")
 
-	go writeResultsToFile(results, *OUTPUT_FILENAME)
-	go writeSkippedToFile(skipped, strings.Split(*OUTPUT_FILENAME, ".")[0]+"_skipped.json")
+	checkpointDone := make(chan struct{})
+	go checkpoint.Run(CHECKPOINT_INTERVAL, checkpointDone)
 
 	wg.Wait()
 	close(results)
-	close(skipped)
+	close(failed)
 	close(inflight)
+	close(checkpointDone)
 
-	log.Println("Scraping completed")
+	logger.Info("scrape_completed")
+}
+
+// buildSource constructs the Source selected by --source, along with the
+// cursor step its batches advance by (used to size checkpoint contiguity).
+func buildSource(startNum int64) (Source, int64) {
+	switch *SOURCE {
+	case "reddit-info":
+		return &RedditInfoSource{StartNum: startNum}, STEP_SIZE
+	case "reddit-listing":
+		if *SUBREDDIT == "" {
+			log.Fatalf("--subreddit is required for --source=reddit-listing")
+		}
+		return NewRedditListingSource(*SUBREDDIT, *LISTING_SIZE), 1
+	case "booru":
+		if *BOORU_URL == "" {
+			log.Fatalf("--booru-url is required for --source=booru")
+		}
+		return &BooruSource{BaseURL: *BOORU_URL, Tags: *BOORU_TAGS, Limit: *BOORU_LIMIT}, 1
+	default:
+		log.Fatalf("Unknown --source %q", *SOURCE)
+		return nil, 0
+	}
 }
 
 type Worker struct {
 	proxy     string
 	userAgent string
 	client    http.Client
+	release   func(ProxyStatus)
 }
 
-func (w *Worker) SetRandomIdentity(proxies, userAgents []string) {
-	w.proxy = proxies[rand.Intn(len(proxies))]
+// SetIdentity acquires a (possibly new) proxy from the pool and a random
+// user agent, rebuilding the worker's client around them. A fresh
+// http.Transport is built every time so the previous proxy's idle TCP
+// connections aren't kept alive (and pooled against) under the new identity.
+func (w *Worker) SetIdentity(pool *ProxyPool, userAgents []string) {
 	w.userAgent = userAgents[rand.Intn(len(userAgents))]
 
+	if w.client.Transport != nil {
+		w.client.CloseIdleConnections()
+	}
 	w.client = http.Client{
-		Timeout: time.Second * 30,
+		Timeout: *PROXY_TIMEOUT,
 	}
 
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: *MAX_IDLE_CONNS_PER_HOST,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: *INSECURE_TLS},
+	}
+	w.client.Transport = transport
+
+	entry, release := pool.Acquire()
+	w.release = release
+	if entry == nil {
+		w.proxy = ""
+		return
+	}
+	w.proxy = entry.URL
+
 	proxyURL, err := url.Parse(w.proxy)
 	if err != nil {
-		log.Printf("Invalid proxy %s: %v", w.proxy, err)
+		logger.Error("invalid_proxy", "proxy", redactProxyURL(w.proxy), "error", err)
 		return
 	}
 
-	w.client.Transport = &http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+		if err != nil {
+			logger.Error("invalid_socks5_proxy", "proxy", redactProxyURL(w.proxy), "error", err)
+			return
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			logger.Error("socks5_dialer_unsupported", "proxy", redactProxyURL(w.proxy))
+			return
+		}
+		transport.DialContext = contextDialer.DialContext
+	default:
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 }
 
-func scrapeWorker(wg *sync.WaitGroup, tasks <-chan int64, skipped chan<- int64, results chan<- []byte, inflight <-chan struct{}, proxies, userAgents []string) {
+func scrapeWorker(ctx context.Context, wg *sync.WaitGroup, tasks <-chan int64, failed chan<- int64, results chan<- Item, inflight <-chan struct{}, pool *ProxyPool, userAgents []string, checkpoint *CheckpointStore, source Source, metrics *Metrics, seqResult chan<- bool) {
 	defer wg.Done()
 
 	worker := Worker{}
-	worker.SetRandomIdentity(proxies, userAgents)
+	worker.SetIdentity(pool, userAgents)
 
 	consecutiveNonValidResponse := 0
 
-	posts := make([]string, STEP_SIZE)
-	for postID := range tasks {
+	for cursor := range tasks {
+		ids, _ := source.NextBatch(cursor)
 
-		for i := range STEP_SIZE {
-			posts[i] = "t3_" + strconv.FormatInt(postID+int64(i), 36)
+		if metrics != nil {
+			metrics.InFlightWorkers.Inc()
+		}
+		items, status, err := worker.DoRequest(ctx, source, ids, metrics)
+		worker.release(status)
+		if metrics != nil {
+			metrics.InFlightWorkers.Dec()
+		}
+		if seqResult != nil {
+			seqResult <- err == nil
 		}
-
-		apiURL := fmt.Sprintf("https://www.reddit.com/api/info.json?id=%s", strings.Join(posts, ","))
-
-		response, err := worker.DoRequest(apiURL)
 		if err != nil {
 			if errors.Is(err, ERR_NON_200_RESPONSE) && consecutiveNonValidResponse < 1 {
 				consecutiveNonValidResponse++
 			} else {
-				log.Printf("re-rolling worker identity")
-				worker.SetRandomIdentity(proxies, userAgents)
+				logger.Info("proxy_rotated", "proxy", redactProxyURL(worker.proxy))
+				worker.SetIdentity(pool, userAgents)
+			}
+			attempts, permanent := checkpoint.MarkSkipped(cursor)
+			if metrics != nil {
+				metrics.PostsSkipped.Inc()
+				metrics.RetriesTotal.Inc()
+			}
+			if permanent {
+				logger.Warn("scrape_failed_permanently", "cursor", cursor, "attempts", attempts, "error", err)
+				// Treat a permanently-failed cursor as contiguity-complete so
+				// highWater keeps advancing past it instead of getting stuck
+				// forever; it's still recorded separately via the failed file
+				// for operator follow-up.
+				checkpoint.MarkCompleted(cursor)
+				failed <- cursor
+			} else {
+				logger.Info("scrape_skipped", "cursor", cursor, "attempts", attempts, "error", err)
 			}
-			log.Printf("%d skipped, will retry", postID)
-			skipped <- postID
 			<-inflight
 			continue
 		} else {
@@ -183,89 +372,94 @@ func scrapeWorker(wg *sync.WaitGroup, tasks <-chan int64, skipped chan<- int64,
 
 		<-inflight
 
-		results <- response
+		for _, item := range items {
+			results <- item
+		}
+		checkpoint.MarkCompleted(cursor)
 
-		log.Printf("Successfully scraped post %d", postID)
+		logger.Info("scrape_ok", "cursor", cursor, "items", len(items), "proxy", redactProxyURL(worker.proxy), "latency_ms", status.Latency.Milliseconds())
+		if metrics != nil {
+			metrics.PostsScraped.Add(float64(len(items)))
+		}
 
 		time.Sleep(time.Second)
 	}
-	fmt.Println("WORKER DONE")
+	logger.Info("worker_done")
 }
 
-func (w *Worker) DoRequest(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// DoRequest builds a request for ids via source, executes it, and parses
+// the response back into individual items. The request is bound to ctx so
+// a shutdown signal aborts an in-flight fetch instead of waiting it out.
+func (w *Worker) DoRequest(ctx context.Context, source Source, ids []string, metrics *Metrics) ([]Item, ProxyStatus, error) {
+	req, err := source.BuildRequest(ids)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request for post: %v", err)
+		return nil, ProxyStatus{Err: err}, fmt.Errorf("failed to build request: %v", err)
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("User-Agent", w.userAgent)
 
+	start := time.Now()
 	resp, err := w.client.Do(req)
+	latency := time.Since(start)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch post: %v", err)
+		return nil, ProxyStatus{Err: err, Latency: latency}, fmt.Errorf("failed to fetch batch: %v", err)
 	}
 	defer resp.Body.Close()
 
+	status := ProxyStatus{
+		StatusCode: resp.StatusCode,
+		Latency:    latency,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	if metrics != nil {
+		metrics.HTTPStatusTotal.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		metrics.RequestLatency.Observe(latency.Seconds())
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Invalid status code for post: %v, sleeping to cool off", resp.StatusCode)
+		logger.Warn("http_status_error", "status", resp.StatusCode)
 		time.Sleep(3 * time.Second)
-		return nil, ERR_NON_200_RESPONSE
+		return nil, status, ERR_NON_200_RESPONSE
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response for post: %v", err)
+		return nil, status, fmt.Errorf("failed to read response for batch: %v", err)
 	}
 
-	if !json.Valid(body) {
-		return nil, fmt.Errorf("invalid JSON response")
+	items, err := source.ParseResponse(body)
+	if err != nil {
+		return nil, status, err
 	}
 
-	return body, nil
+	return items, status, nil
 }
 
-func writeSkippedToFile(skipped <-chan int64, outputFile string) {
+// writeFailedToFile records cursors that exhausted their retry budget, so
+// they can be inspected or re-submitted by hand instead of being lost.
+func writeFailedToFile(failed <-chan int64, outputFile string) {
 	f, err := os.Create(outputFile)
 	if err != nil {
 		log.Fatalf("Failed to create output file: %v", err)
 	}
 	defer f.Close()
-	for id := range skipped {
+	for id := range failed {
 		f.WriteString(strconv.Itoa(int(id)) + "\n")
 	}
 }
 
-func writeResultsToFile(results <-chan []byte, outputFile string) {
-	f, err := os.Create(outputFile)
-	if err != nil {
-		log.Fatalf("Failed to create output file: %v", err)
-	}
-	defer f.Close()
-
-	_, err = f.Write([]byte("["))
-	if err != nil {
-		log.Fatalf("Failed to write to output file: %v", err)
-	}
+func writeResultsToFile(results <-chan []byte, store Store, metrics *Metrics) {
+	defer store.Close()
 
-	first := true
 	for body := range results {
-		if !first {
-			_, err = f.Write([]byte(","))
-			if err != nil {
-				log.Fatalf("Failed to write to output file: %v", err)
-			}
-		}
-		first = false
-
-		_, err = f.Write(body)
-		if err != nil {
+		if err := store.WriteResult(body); err != nil {
 			log.Fatalf("Failed to write to output file: %v", err)
 		}
+		if metrics != nil {
+			metrics.LastFlushTimestamp.SetToCurrentTime()
+		}
 	}
 
-	_, err = f.Write([]byte("]"))
-	if err != nil {
-		log.Fatalf("Failed to write to output file: %v", err)
-	}
-
-	log.Printf("Successfully wrote to %s", outputFile)
+	logger.Info("results_writer_done")
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Item is a single scraped record (one Reddit post, one booru post, ...)
+// already serialized as its own JSON object, ready to hand to a Store.
+type Item = []byte
+
+// Source abstracts a site-specific scraping backend: how to enumerate
+// batches of work, how to turn a batch into an HTTP request, and how to
+// pull individual items back out of the response body. cursor is an
+// opaque, monotonically increasing offset into the source's enumeration
+// (a numeric post-ID offset, a booru pid, a listing page index, ...) so it
+// composes with the existing checkpoint/resume offset tracking.
+type Source interface {
+	// NextBatch returns the ids/params to fetch for cursor, plus the cursor
+	// that should be requested after it.
+	NextBatch(cursor int64) (ids []string, nextCursor int64)
+	// BuildRequest builds the HTTP request that fetches ids.
+	BuildRequest(ids []string) (*http.Request, error)
+	// ParseResponse extracts the individual items out of a raw response body.
+	ParseResponse(body []byte) ([]Item, error)
+	// Sequential reports whether batches must be fetched strictly in cursor
+	// order. True for response-driven pagination (the next cursor can only
+	// be learned by parsing the previous response); false for sources whose
+	// cursor is a pure arithmetic offset and so can be fetched out of order
+	// by a worker pool.
+	Sequential() bool
+}
+
+// --- RedditInfoSource ----------------------------------------------------
+
+// RedditInfoSource fetches STEP_SIZE posts per request by guessing
+// contiguous base36 post IDs against /api/info.json, same as the original
+// hardwired scraper.
+type RedditInfoSource struct {
+	StartNum int64
+}
+
+func (s *RedditInfoSource) NextBatch(cursor int64) (ids []string, nextCursor int64) {
+	ids = make([]string, STEP_SIZE)
+	for i := range ids {
+		ids[i] = "t3_" + strconv.FormatInt(s.StartNum+cursor+int64(i), 36)
+	}
+	return ids, cursor + STEP_SIZE
+}
+
+func (s *RedditInfoSource) BuildRequest(ids []string) (*http.Request, error) {
+	apiURL := fmt.Sprintf("https://www.reddit.com/api/info.json?id=%s", strings.Join(ids, ","))
+	return http.NewRequest("GET", apiURL, nil)
+}
+
+func (s *RedditInfoSource) ParseResponse(body []byte) ([]Item, error) {
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("invalid JSON response")
+	}
+	var listing struct {
+		Data struct {
+			Children []json.RawMessage `json:"children"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse reddit info response: %v", err)
+	}
+	items := make([]Item, len(listing.Data.Children))
+	for i, c := range listing.Data.Children {
+		items[i] = Item(c)
+	}
+	return items, nil
+}
+
+func (s *RedditInfoSource) Sequential() bool { return false }
+
+// --- RedditListingSource --------------------------------------------------
+
+// RedditListingSource follows `after=` pagination on /r/<sub>/new.json.
+// Since the "after" token for page N+1 is only known once page N's
+// response has been parsed, batches must be fetched strictly in cursor
+// order (see Sequential).
+type RedditListingSource struct {
+	Subreddit string
+	Limit     int
+
+	mu            sync.Mutex
+	afterByCursor map[int64]string
+	pendingCursor int64
+}
+
+func NewRedditListingSource(subreddit string, limit int) *RedditListingSource {
+	return &RedditListingSource{
+		Subreddit:     subreddit,
+		Limit:         limit,
+		afterByCursor: map[int64]string{0: ""},
+	}
+}
+
+func (s *RedditListingSource) NextBatch(cursor int64) (ids []string, nextCursor int64) {
+	s.mu.Lock()
+	after := s.afterByCursor[cursor]
+	s.pendingCursor = cursor
+	s.mu.Unlock()
+
+	return []string{after}, cursor + 1
+}
+
+func (s *RedditListingSource) BuildRequest(ids []string) (*http.Request, error) {
+	apiURL := fmt.Sprintf("https://www.reddit.com/r/%s/new.json?limit=%d", s.Subreddit, s.Limit)
+	if after := ids[0]; after != "" {
+		apiURL += "&after=" + url.QueryEscape(after)
+	}
+	return http.NewRequest("GET", apiURL, nil)
+}
+
+func (s *RedditListingSource) ParseResponse(body []byte) ([]Item, error) {
+	var listing struct {
+		Data struct {
+			After    string            `json:"after"`
+			Children []json.RawMessage `json:"children"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse reddit listing response: %v", err)
+	}
+
+	s.mu.Lock()
+	s.afterByCursor[s.pendingCursor+1] = listing.Data.After
+	s.mu.Unlock()
+
+	items := make([]Item, len(listing.Data.Children))
+	for i, c := range listing.Data.Children {
+		items[i] = Item(c)
+	}
+	return items, nil
+}
+
+func (s *RedditListingSource) Sequential() bool { return true }
+
+// --- BooruSource ------------------------------------------------------
+
+// BooruSource targets gelbooru/r34-style JSON APIs, which paginate with a
+// numeric pid (page index) independent of response content, so (unlike
+// RedditListingSource) it can be fetched out of order by a worker pool.
+type BooruSource struct {
+	BaseURL string // e.g. "https://api.rule34.xxx/index.php?page=dapi&s=post&q=index&json=1"
+	Tags    string
+	Limit   int
+}
+
+func (s *BooruSource) NextBatch(cursor int64) (ids []string, nextCursor int64) {
+	return []string{strconv.FormatInt(cursor, 10)}, cursor + 1
+}
+
+func (s *BooruSource) BuildRequest(ids []string) (*http.Request, error) {
+	apiURL := fmt.Sprintf("%s&pid=%s&limit=%d", s.BaseURL, ids[0], s.Limit)
+	if s.Tags != "" {
+		apiURL += "&tags=" + url.QueryEscape(s.Tags)
+	}
+	return http.NewRequest("GET", apiURL, nil)
+}
+
+// booruPost is the subset of gelbooru-style post fields we care about.
+type booruPost struct {
+	ID      int64  `json:"id"`
+	FileURL string `json:"file_url"`
+	Hash    string `json:"hash"`
+	Rating  string `json:"rating"`
+}
+
+func (s *BooruSource) ParseResponse(body []byte) ([]Item, error) {
+	var posts []booruPost
+	if err := json.Unmarshal(body, &posts); err != nil {
+		return nil, fmt.Errorf("failed to parse booru response: %v", err)
+	}
+	items := make([]Item, len(posts))
+	for i, p := range posts {
+		encoded, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encode booru post: %v", err)
+		}
+		items[i] = encoded
+	}
+	return items, nil
+}
+
+func (s *BooruSource) Sequential() bool { return false }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store persists scraped results in a way that survives a crash. Unlike the
+// old single JSON array file, a Store can be safely appended to and tailed
+// while the scraper is still running.
+type Store interface {
+	WriteResult(body []byte) error
+	Close() error
+}
+
+// NDJSONStore appends one JSON object per line (newline-delimited JSON). If
+// the process is killed mid-write the file is left with a trailing partial
+// line at worst, instead of an unterminated `[` that makes the whole file
+// invalid.
+type NDJSONStore struct {
+	f *os.File
+}
+
+// NewNDJSONStore opens outputFile for appending, creating it if necessary.
+func NewNDJSONStore(outputFile string) (*NDJSONStore, error) {
+	f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &NDJSONStore{f: f}, nil
+}
+
+func (s *NDJSONStore) WriteResult(body []byte) error {
+	if _, err := s.f.Write(body); err != nil {
+		return err
+	}
+	_, err := s.f.Write([]byte("\n"))
+	return err
+}
+
+func (s *NDJSONStore) Close() error {
+	return s.f.Close()
+}
+
+// Checkpoint is the on-disk representation of a CheckpointStore, recording
+// enough state to resume a run with --resume instead of --start-id.
+type Checkpoint struct {
+	StartNum int64           `json:"start_num"`
+	Offset   int64           `json:"offset"` // highest contiguously-completed offset from StartNum
+	Skipped  []SkippedCursor `json:"skipped"`
+}
+
+// SkippedCursor is a pending retry's on-disk representation, preserving
+// enough of its RetryState that --resume doesn't hand it a fresh retry
+// budget.
+type SkippedCursor struct {
+	Cursor       int64     `json:"cursor"`
+	Attempts     int       `json:"attempts"`
+	NextEligible time.Time `json:"next_eligible"`
+}
+
+// RetryState tracks how many times a cursor has failed and when it's next
+// eligible to be retried (exponential backoff), so a consistently-failing
+// batch doesn't spin the feeder in a tight retry loop.
+type RetryState struct {
+	Attempts     int
+	NextEligible time.Time
+	inFlight     bool // handed out by NextRetry, outcome not yet reported
+}
+
+const (
+	maxRetryAttempts = 5
+	baseRetryBackoff = 5 * time.Second
+)
+
+// CheckpointStore periodically fsyncs progress.json so a killed run can be
+// resumed later. It tracks the highest contiguously-completed cursor (gaps
+// from in-flight or retried batches are not counted) plus the set of
+// cursors still pending in skipped/retry. cursor is whatever a Source's
+// NextBatch uses (a post-ID offset, a booru pid, a listing page index); step
+// is the amount NextBatch advances the cursor by on each call, so the
+// contiguity check knows what "the next batch" looks like.
+type CheckpointStore struct {
+	mu        sync.Mutex
+	path      string
+	startNum  int64
+	step      int64
+	highWater int64 // last contiguous cursor that has completed
+	completed map[int64]bool
+	skipped   map[int64]*RetryState
+}
+
+// NewCheckpointStore creates a checkpoint store seeded at resumeOffset, the
+// first cursor still to be fetched (every cursor before it counts as
+// completed), recording startNum purely as metadata (e.g. the base36 post ID
+// a RedditInfoSource cursor is relative to) for whatever reads progress.json.
+func NewCheckpointStore(path string, startNum, resumeOffset, step int64) *CheckpointStore {
+	return &CheckpointStore{
+		path:      path,
+		startNum:  startNum,
+		step:      step,
+		highWater: resumeOffset - step,
+		completed: make(map[int64]bool),
+		skipped:   make(map[int64]*RetryState),
+	}
+}
+
+// MarkCompleted records that the batch at cursor finished successfully, drops
+// any retry state it had, and advances the contiguous high-water mark if
+// possible.
+func (c *CheckpointStore) MarkCompleted(cursor int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.skipped, cursor)
+	c.completed[cursor] = true
+	for c.completed[c.highWater+c.step] {
+		c.highWater += c.step
+		delete(c.completed, c.highWater)
+	}
+}
+
+// MarkSkipped records a failed attempt at cursor and computes its next
+// eligible retry time via exponential backoff. Once attempts reaches
+// maxRetryAttempts, cursor is forgotten and permanent=true is returned, so
+// the caller can route it to a permanent failures file instead of retrying
+// it forever.
+func (c *CheckpointStore) MarkSkipped(cursor int64) (attempts int, permanent bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.skipped[cursor]
+	if state == nil {
+		state = &RetryState{}
+		c.skipped[cursor] = state
+	}
+	state.Attempts++
+	state.inFlight = false
+
+	if state.Attempts >= maxRetryAttempts {
+		delete(c.skipped, cursor)
+		return state.Attempts, true
+	}
+
+	shift := state.Attempts - 1
+	if shift > 5 {
+		shift = 5
+	}
+	state.NextEligible = time.Now().Add(baseRetryBackoff * time.Duration(int64(1)<<uint(shift)))
+	return state.Attempts, false
+}
+
+// RestoreSkipped re-seeds a cursor's retry state from a previously flushed
+// checkpoint, preserving its attempt count and next-eligible time instead of
+// giving it a fresh retry budget on every --resume.
+func (c *CheckpointStore) RestoreSkipped(cursor int64, attempts int, nextEligible time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.skipped[cursor] = &RetryState{Attempts: attempts, NextEligible: nextEligible}
+}
+
+// NextRetry returns a pending cursor whose backoff has elapsed, or ok=false
+// if none are ready yet. The cursor keeps its retry state (and so its attempt
+// count) but is not handed out again until MarkSkipped reports another
+// failure; MarkCompleted forgets it.
+func (c *CheckpointStore) NextRetry(now time.Time) (cursor int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, state := range c.skipped {
+		if !state.inFlight && now.After(state.NextEligible) {
+			state.inFlight = true
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (c *CheckpointStore) snapshot() Checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	skipped := make([]SkippedCursor, 0, len(c.skipped))
+	for id, state := range c.skipped {
+		skipped = append(skipped, SkippedCursor{Cursor: id, Attempts: state.Attempts, NextEligible: state.NextEligible})
+	}
+	return Checkpoint{
+		StartNum: c.startNum,
+		Offset:   c.highWater,
+		Skipped:  skipped,
+	}
+}
+
+// flush writes the current snapshot to path, fsyncing so it survives a crash.
+func (c *CheckpointStore) flush() error {
+	cp := c.snapshot()
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(cp); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// Run periodically flushes the checkpoint to disk until stop is closed, then
+// flushes one last time before returning.
+func (c *CheckpointStore) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				logger.Error("checkpoint_write_failed", "error", err)
+			}
+		case <-stop:
+			if err := c.flush(); err != nil {
+				logger.Error("checkpoint_write_failed", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// LoadCheckpoint reads a previously-flushed checkpoint from path.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}